@@ -0,0 +1,55 @@
+//Package message defines the messages passed between clients and rooms.
+package message
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+)
+
+//Message is anything that can be sent through a Room and printed to a client.
+type Message interface {
+	fmt.Stringer
+}
+
+//ServerMessage is a message originating from the server itself rather than a client.
+type ServerMessage struct {
+	Text string
+}
+
+//NewServerMessage returns a Message wrapping s as coming from the server.
+func NewServerMessage(s string) Message {
+	return ServerMessage{Text: s}
+}
+
+//String returns the text of the message.
+func (m ServerMessage) String() string {
+	return m.Text
+}
+
+//ModeChange is a message announcing that a room's mode or client limit changed.
+type ModeChange struct {
+	Room  string
+	Modes string
+}
+
+//NewModeChange returns a Message announcing that room changed its modes as described by modes.
+func NewModeChange(room, modes string) Message {
+	return ModeChange{Room: room, Modes: modes}
+}
+
+//String returns the mode change formatted as an IRC-style MODE announcement.
+func (m ModeChange) String() string {
+	return fmt.Sprintf("* %s mode %s", m.Room, m.Modes)
+}
+
+//MessageList is a mutex enhanced linked list of messages.
+type MessageList struct {
+	*list.List
+	*sync.Mutex
+}
+
+//NewMessageList returns a pointer to an empty MessageList.
+func NewMessageList() *MessageList {
+	return &MessageList{list.New(), new(sync.Mutex)}
+}
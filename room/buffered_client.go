@@ -0,0 +1,134 @@
+package room
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/davidafox/chat/message"
+)
+
+//OverflowPolicy controls what a BufferedClient does when its queue is full.
+type OverflowPolicy int
+
+const (
+	//DropOldest discards the oldest queued message to make room for the new one.
+	DropOldest OverflowPolicy = iota
+	//DropNewest discards the incoming message, leaving the queue as is.
+	DropNewest
+	//DisconnectOnOverflow closes the client instead of dropping a message.
+	DisconnectOnOverflow
+)
+
+//BufferedClient wraps a Client with a bounded queue and a dedicated goroutine
+//that drains it to the underlying Client, so a slow Recieve implementation
+//can't stall the room's Send/Recieve for every other client.
+type BufferedClient struct {
+	Client
+	queue   chan message.Message
+	policy  OverflowPolicy
+	dropped uint64
+
+	mu           sync.RWMutex
+	closed       bool
+	onDisconnect func()
+}
+
+//NewBufferedClient returns a BufferedClient delivering to cl through a queue
+//of size bufferSize, applying policy when the queue is full.
+func NewBufferedClient(cl Client, bufferSize int, policy OverflowPolicy) *BufferedClient {
+	bc := &BufferedClient{
+		Client: cl,
+		queue:  make(chan message.Message, bufferSize),
+		policy: policy,
+	}
+	go bc.drain()
+	return bc
+}
+
+//Equals compares the clients bc wraps, so a BufferedClient is interchangeable
+//with the Client it was constructed from and with another BufferedClient
+//wrapping the same client.
+func (bc *BufferedClient) Equals(other Client) bool {
+	if ob, ok := other.(*BufferedClient); ok {
+		return bc.Client.Equals(ob.Client)
+	}
+	return bc.Client.Equals(other)
+}
+
+//drain delivers queued messages to the underlying client until the queue is closed.
+func (bc *BufferedClient) drain() {
+	for m := range bc.queue {
+		bc.Client.Recieve(m)
+	}
+}
+
+//Recieve enqueues m for delivery, applying the client's OverflowPolicy if
+//the queue is full. It never blocks, and is a no-op once the client is closed.
+func (bc *BufferedClient) Recieve(m message.Message) {
+	bc.mu.RLock()
+	if bc.closed {
+		bc.mu.RUnlock()
+		return
+	}
+	select {
+	case bc.queue <- m:
+		bc.mu.RUnlock()
+		return
+	default:
+	}
+	if bc.policy == DisconnectOnOverflow {
+		bc.mu.RUnlock()
+		bc.Close()
+		return
+	}
+	if bc.policy == DropOldest {
+		select {
+		case <-bc.queue:
+		default:
+		}
+		select {
+		case bc.queue <- m:
+		default:
+		}
+	}
+	bc.recordDropLocked()
+	bc.mu.RUnlock()
+}
+
+//recordDropLocked increments the dropped count and queues a notice telling the
+//client a message was lost. The notice is queued only if the queue has room
+//for it; when the queue is full, as it usually is right after a drop, the
+//notice is discarded and the client is not told. The caller must hold bc.mu
+//(for reading) and bc.closed must be false.
+func (bc *BufferedClient) recordDropLocked() {
+	atomic.AddUint64(&bc.dropped, 1)
+	notice := message.NewServerMessage("a message was dropped because you are receiving messages too slowly")
+	select {
+	case bc.queue <- notice:
+	default:
+	}
+}
+
+//Dropped returns the number of messages dropped for this client so far.
+func (bc *BufferedClient) Dropped() uint64 {
+	return atomic.LoadUint64(&bc.dropped)
+}
+
+//Close stops the drain goroutine, runs the onDisconnect hook set by the room
+//that created bc, and closes the underlying client. It is safe to call more
+//than once or concurrently with Recieve.
+func (bc *BufferedClient) Close() {
+	bc.mu.Lock()
+	if bc.closed {
+		bc.mu.Unlock()
+		return
+	}
+	bc.closed = true
+	close(bc.queue)
+	bc.mu.Unlock()
+
+	if bc.onDisconnect != nil {
+		bc.onDisconnect()
+	}
+	bc.Client.Close()
+}
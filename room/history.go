@@ -0,0 +1,311 @@
+package room
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"path/filepath"
+	"sync"
+
+	"github.com/davidafox/chat/message"
+	"go.etcd.io/bbolt"
+)
+
+func init() {
+	gob.Register(message.ServerMessage{})
+	gob.Register(message.ModeChange{})
+}
+
+//history is the backing store for a room's message log. It is implemented by
+//memHistory for rooms with no persistence and by boltHistory for rooms opened
+//with NewRoomWithHistory.
+type history interface {
+	//Append encodes and stores m, returning the sequence number it was stored under.
+	Append(m message.Message) (uint64, error)
+	//Recent returns up to limit messages older than before (or the newest limit
+	//messages if before is 0), and the cursor to pass as before to fetch the next
+	//older page. A returned cursor of 0 means there is nothing older left.
+	Recent(before uint64, limit int) ([]message.Message, uint64, error)
+	//SaveMeta persists the room's mode state alongside its messages.
+	SaveMeta(meta roomMeta) error
+	//LoadMeta returns the room's previously persisted mode state, if any.
+	LoadMeta() (roomMeta, error)
+	Close() error
+}
+
+//roomMeta is the persisted mode and per-client state of a Room.
+type roomMeta struct {
+	Topic   string
+	Key     string
+	Modes   RoomMode
+	Limit   int
+	Invited []string
+
+	//Tags maps a client name to its tags, each mapping a tag name to its sort order.
+	Tags map[string]map[string]float64
+	//Direct holds the names of clients that have marked this room as a direct chat.
+	Direct []string
+}
+
+//Each room's bbolt bucket holds two nested buckets, so roomMeta can never
+//collide with or be mistaken for a sequence-keyed message: messagesBucket
+//holds the message log, metaBucket holds the single roomMeta record.
+var (
+	messagesBucket = []byte("messages")
+	metaBucket     = []byte("meta")
+	metaKey        = []byte("meta")
+)
+
+//memHistory is an in-memory history used by rooms that are not backed by disk.
+type memHistory struct {
+	*message.MessageList
+	next uint64
+
+	metaMu sync.Mutex
+	meta   roomMeta
+}
+
+type memEntry struct {
+	seq uint64
+	msg message.Message
+}
+
+func newMemHistory() *memHistory {
+	return &memHistory{MessageList: message.NewMessageList()}
+}
+
+func (h *memHistory) Append(m message.Message) (uint64, error) {
+	h.Lock()
+	h.next++
+	seq := h.next
+	h.PushBack(memEntry{seq, m})
+	h.Unlock()
+	return seq, nil
+}
+
+func (h *memHistory) Recent(before uint64, limit int) ([]message.Message, uint64, error) {
+	h.Lock()
+	defer h.Unlock()
+	entries := make([]memEntry, 0, h.Len())
+	for i := h.Front(); i != nil; i = i.Next() {
+		entries = append(entries, i.Value.(memEntry))
+	}
+	end := len(entries)
+	if before != 0 {
+		for end > 0 && entries[end-1].seq >= before {
+			end--
+		}
+	}
+	start := end - limit
+	if start < 0 {
+		start = 0
+	}
+	msgs := make([]message.Message, end-start)
+	for i, e := range entries[start:end] {
+		msgs[i] = e.msg
+	}
+	var next uint64
+	if start > 0 {
+		next = entries[start].seq
+	}
+	return msgs, next, nil
+}
+
+func (h *memHistory) SaveMeta(meta roomMeta) error {
+	h.metaMu.Lock()
+	h.meta = meta
+	h.metaMu.Unlock()
+	return nil
+}
+
+func (h *memHistory) LoadMeta() (roomMeta, error) {
+	h.metaMu.Lock()
+	defer h.metaMu.Unlock()
+	return h.meta, nil
+}
+
+func (h *memHistory) Close() error {
+	return nil
+}
+
+//boltHistory persists a room's messages to a bucket, keyed by room name, in a
+//shared bbolt database file. Each message is gob-encoded and gzip-compressed
+//before being written.
+type boltHistory struct {
+	db     *sharedBoltDB
+	bucket []byte
+}
+
+//sharedBoltDB reference-counts a *bbolt.DB so that every room backed by the
+//same storageDir shares one open file handle instead of each taking its own
+//exclusive flock on history.db, which would deadlock as soon as a second room
+//tried to open it.
+type sharedBoltDB struct {
+	*bbolt.DB
+	path string
+	refs int
+}
+
+var (
+	sharedBoltDBsMu sync.Mutex
+	sharedBoltDBs   = make(map[string]*sharedBoltDB)
+)
+
+//openSharedBoltDB opens path if it isn't already open in this process, or
+//returns the existing handle with its reference count incremented.
+func openSharedBoltDB(path string) (*sharedBoltDB, error) {
+	sharedBoltDBsMu.Lock()
+	defer sharedBoltDBsMu.Unlock()
+	if s, found := sharedBoltDBs[path]; found {
+		s.refs++
+		return s, nil
+	}
+	db, err := bbolt.Open(path, 0600, nil)
+	if err != nil {
+		return nil, fmt.Errorf("room: opening history store: %w", err)
+	}
+	s := &sharedBoltDB{DB: db, path: path, refs: 1}
+	sharedBoltDBs[path] = s
+	return s, nil
+}
+
+//Close decrements s's reference count, closing the underlying *bbolt.DB once
+//the last room using it releases it.
+func (s *sharedBoltDB) Close() error {
+	sharedBoltDBsMu.Lock()
+	defer sharedBoltDBsMu.Unlock()
+	s.refs--
+	if s.refs > 0 {
+		return nil
+	}
+	delete(sharedBoltDBs, s.path)
+	return s.DB.Close()
+}
+
+//openBoltHistory opens (creating if necessary) the history bucket for
+//roomName inside storageDir/history.db.
+func openBoltHistory(storageDir, roomName string) (*boltHistory, error) {
+	db, err := openSharedBoltDB(filepath.Join(storageDir, "history.db"))
+	if err != nil {
+		return nil, err
+	}
+	bucket := []byte(roomName)
+	err = db.Update(func(tx *bbolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(bucket)
+		if err != nil {
+			return err
+		}
+		if _, err := b.CreateBucketIfNotExists(messagesBucket); err != nil {
+			return err
+		}
+		_, err = b.CreateBucketIfNotExists(metaBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("room: creating history bucket for %s: %w", roomName, err)
+	}
+	return &boltHistory{db: db, bucket: bucket}, nil
+}
+
+func (h *boltHistory) Append(m message.Message) (uint64, error) {
+	var seq uint64
+	err := h.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(h.bucket).Bucket(messagesBucket)
+		id, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		data, err := encodeMessage(m)
+		if err != nil {
+			return err
+		}
+		seq = id
+		return b.Put(seqKey(seq), data)
+	})
+	return seq, err
+}
+
+func (h *boltHistory) Recent(before uint64, limit int) ([]message.Message, uint64, error) {
+	var msgs []message.Message
+	var next uint64
+	err := h.db.View(func(tx *bbolt.Tx) error {
+		c := tx.Bucket(h.bucket).Bucket(messagesBucket).Cursor()
+		var k, v []byte
+		if before == 0 {
+			k, v = c.Last()
+		} else {
+			c.Seek(seqKey(before))
+			k, v = c.Prev()
+		}
+		for ; k != nil && len(msgs) < limit; k, v = c.Prev() {
+			m, err := decodeMessage(v)
+			if err != nil {
+				return err
+			}
+			msgs = append([]message.Message{m}, msgs...)
+			next = binary.BigEndian.Uint64(k)
+		}
+		return nil
+	})
+	return msgs, next, err
+}
+
+func (h *boltHistory) SaveMeta(meta roomMeta) error {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(meta); err != nil {
+		return err
+	}
+	return h.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(h.bucket).Bucket(metaBucket).Put(metaKey, buf.Bytes())
+	})
+}
+
+func (h *boltHistory) LoadMeta() (roomMeta, error) {
+	var meta roomMeta
+	err := h.db.View(func(tx *bbolt.Tx) error {
+		data := tx.Bucket(h.bucket).Bucket(metaBucket).Get(metaKey)
+		if data == nil {
+			return nil
+		}
+		return gob.NewDecoder(bytes.NewReader(data)).Decode(&meta)
+	})
+	return meta, err
+}
+
+func (h *boltHistory) Close() error {
+	return h.db.Close()
+}
+
+func seqKey(seq uint64) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, seq)
+	return k
+}
+
+func encodeMessage(m message.Message) ([]byte, error) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if err := gob.NewEncoder(gz).Encode(&m); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decodeMessage(data []byte) (message.Message, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+	var m message.Message
+	if err := gob.NewDecoder(gz).Decode(&m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
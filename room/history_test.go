@@ -0,0 +1,127 @@
+package room
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/davidafox/chat/message"
+)
+
+//TestRoomGetMessagesRejectsNonPositiveLimit guards against a negative limit
+//reaching memHistory.Recent, which panics computing a negative slice length.
+func TestRoomGetMessagesRejectsNonPositiveLimit(t *testing.T) {
+	rm := NewRoom("general")
+	rm.Send(message.NewServerMessage("hello"))
+
+	if _, _, err := rm.GetMessages(0, -1); err != ErrInvalidLimit {
+		t.Fatalf("expected ErrInvalidLimit for limit -1, got %v", err)
+	}
+	if _, _, err := rm.GetMessages(0, 0); err != ErrInvalidLimit {
+		t.Fatalf("expected ErrInvalidLimit for limit 0, got %v", err)
+	}
+}
+
+func TestBoltHistoryAppendAndRecent(t *testing.T) {
+	h, err := openBoltHistory(t.TempDir(), "general")
+	if err != nil {
+		t.Fatalf("openBoltHistory: %v", err)
+	}
+	defer h.Close()
+
+	for i := 0; i < 5; i++ {
+		if _, err := h.Append(message.NewServerMessage(fmt.Sprintf("msg%d", i))); err != nil {
+			t.Fatalf("Append: %v", err)
+		}
+	}
+
+	msgs, cursor, err := h.Recent(0, 2)
+	if err != nil {
+		t.Fatalf("Recent: %v", err)
+	}
+	if len(msgs) != 2 {
+		t.Fatalf("expected 2 messages, got %d", len(msgs))
+	}
+	if fmt.Sprint(msgs[0]) != "msg3" || fmt.Sprint(msgs[1]) != "msg4" {
+		t.Fatalf("unexpected newest page: %v", msgs)
+	}
+	if cursor == 0 {
+		t.Fatal("expected a non-zero cursor with older messages left")
+	}
+
+	older, _, err := h.Recent(cursor, 2)
+	if err != nil {
+		t.Fatalf("Recent older page: %v", err)
+	}
+	if len(older) != 2 || fmt.Sprint(older[0]) != "msg1" || fmt.Sprint(older[1]) != "msg2" {
+		t.Fatalf("unexpected older page: %v", older)
+	}
+}
+
+//TestBoltHistorySaveMetaDoesNotBreakRecent guards against metaBucket and
+//messagesBucket colliding: saving mode state must not corrupt pagination.
+func TestBoltHistorySaveMetaDoesNotBreakRecent(t *testing.T) {
+	h, err := openBoltHistory(t.TempDir(), "general")
+	if err != nil {
+		t.Fatalf("openBoltHistory: %v", err)
+	}
+	defer h.Close()
+
+	for i := 0; i < 3; i++ {
+		if _, err := h.Append(message.NewServerMessage(fmt.Sprintf("msg%d", i))); err != nil {
+			t.Fatalf("Append: %v", err)
+		}
+	}
+	if err := h.SaveMeta(roomMeta{Topic: "welcome"}); err != nil {
+		t.Fatalf("SaveMeta: %v", err)
+	}
+
+	msgs, _, err := h.Recent(0, 3)
+	if err != nil {
+		t.Fatalf("Recent after SaveMeta: %v", err)
+	}
+	if len(msgs) != 3 {
+		t.Fatalf("expected 3 messages after SaveMeta, got %d", len(msgs))
+	}
+
+	meta, err := h.LoadMeta()
+	if err != nil {
+		t.Fatalf("LoadMeta: %v", err)
+	}
+	if meta.Topic != "welcome" {
+		t.Fatalf("expected topic %q, got %q", "welcome", meta.Topic)
+	}
+}
+
+func TestRoomLoadHistoryRehydratesFromDisk(t *testing.T) {
+	dir := t.TempDir()
+
+	rm, err := NewRoomWithHistory("general", dir)
+	if err != nil {
+		t.Fatalf("NewRoomWithHistory: %v", err)
+	}
+	rm.Send(message.NewServerMessage("hello"))
+	rm.SetTopic("welcome")
+	if err := rm.messages.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	reopened, err := NewRoomWithHistory("general", dir)
+	if err != nil {
+		t.Fatalf("NewRoomWithHistory (reopen): %v", err)
+	}
+	defer reopened.messages.Close()
+	if err := reopened.LoadHistory(); err != nil {
+		t.Fatalf("LoadHistory: %v", err)
+	}
+
+	msgs, _, err := reopened.GetMessages(0, 10)
+	if err != nil {
+		t.Fatalf("GetMessages: %v", err)
+	}
+	if len(msgs) != 2 || fmt.Sprint(msgs[0]) != "hello" {
+		t.Fatalf("expected reloaded history to contain \"hello\", got %v", msgs)
+	}
+	if reopened.Topic() != "welcome" {
+		t.Fatalf("expected reloaded topic %q, got %q", "welcome", reopened.Topic())
+	}
+}
@@ -0,0 +1,175 @@
+package room
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/davidafox/chat/message"
+)
+
+//RoomMode is a bitset of the modes a Room can have, analogous to IRC channel modes.
+type RoomMode uint8
+
+const (
+	//ModeInviteOnly restricts Join to clients on the invite list (+i).
+	ModeInviteOnly RoomMode = 1 << iota
+	//ModeModerated marks the room as moderated (+m). It is recorded, persisted,
+	//and announced via SetMode/ClearMode like any other mode, but Send and
+	//Recieve do not currently consult it: nothing in this package restricts who
+	//may send while it is set.
+	ModeModerated
+	//ModeSecret hides the room from untargeted listings (+s).
+	ModeSecret
+)
+
+//Errors returned by Join when a client does not meet the room's access requirements.
+var (
+	ErrBadKey     = errors.New("room: wrong key")
+	ErrInviteOnly = errors.New("room: invite only")
+	ErrRoomFull   = errors.New("room: full")
+)
+
+//Topic returns the room's topic.
+func (rm *Room) Topic() string {
+	rm.meta.RLock()
+	defer rm.meta.RUnlock()
+	return rm.topic
+}
+
+//SetTopic sets the room's topic and announces the change to the room.
+func (rm *Room) SetTopic(topic string) {
+	rm.meta.Lock()
+	rm.topic = topic
+	rm.meta.Unlock()
+	rm.persistMeta()
+	rm.Send(message.NewServerMessage(fmt.Sprintf("topic changed to: %s", topic)))
+}
+
+//SetKey sets the key required to Join the room. An empty key removes the requirement.
+func (rm *Room) SetKey(key string) {
+	rm.meta.Lock()
+	rm.key = key
+	rm.meta.Unlock()
+	rm.persistMeta()
+}
+
+//Invite adds name to the room's invite list, allowing it to Join a +i room.
+func (rm *Room) Invite(name string) {
+	rm.meta.Lock()
+	rm.invited[name] = struct{}{}
+	rm.meta.Unlock()
+	rm.persistMeta()
+}
+
+//IsInvited returns true if name is on the room's invite list.
+func (rm *Room) IsInvited(name string) bool {
+	rm.meta.RLock()
+	defer rm.meta.RUnlock()
+	_, found := rm.invited[name]
+	return found
+}
+
+//HasMode returns true if m is set on the room.
+func (rm *Room) HasMode(m RoomMode) bool {
+	rm.meta.RLock()
+	defer rm.meta.RUnlock()
+	return rm.modes&m != 0
+}
+
+//SetMode sets m on the room and announces the change to the room.
+func (rm *Room) SetMode(m RoomMode) {
+	rm.meta.Lock()
+	rm.modes |= m
+	rm.meta.Unlock()
+	rm.persistMeta()
+	rm.Send(message.NewModeChange(rm.name, "+"+modeString(m)))
+}
+
+//ClearMode clears m on the room and announces the change to the room.
+func (rm *Room) ClearMode(m RoomMode) {
+	rm.meta.Lock()
+	rm.modes &^= m
+	rm.meta.Unlock()
+	rm.persistMeta()
+	rm.Send(message.NewModeChange(rm.name, "-"+modeString(m)))
+}
+
+//SetLimit sets the maximum number of clients Join will admit. A limit of 0 means unlimited.
+func (rm *Room) SetLimit(n int) {
+	rm.meta.Lock()
+	rm.limit = n
+	rm.meta.Unlock()
+	rm.persistMeta()
+	rm.Send(message.NewModeChange(rm.name, fmt.Sprintf("+l %d", n)))
+}
+
+//persistMeta snapshots the room's current mode and per-client tag state and
+//writes it to the history store.
+func (rm *Room) persistMeta() {
+	rm.meta.RLock()
+	meta := roomMeta{
+		Topic:   rm.topic,
+		Key:     rm.key,
+		Modes:   rm.modes,
+		Limit:   rm.limit,
+		Invited: make([]string, 0, len(rm.invited)),
+	}
+	for name := range rm.invited {
+		meta.Invited = append(meta.Invited, name)
+	}
+	rm.meta.RUnlock()
+
+	rm.tagsMu.RLock()
+	meta.Tags = make(map[string]map[string]float64, len(rm.tags))
+	for clientName, tags := range rm.tags {
+		copied := make(map[string]float64, len(tags))
+		for tag, order := range tags {
+			copied[tag] = order
+		}
+		meta.Tags[clientName] = copied
+	}
+	meta.Direct = make([]string, 0, len(rm.direct))
+	for name := range rm.direct {
+		meta.Direct = append(meta.Direct, name)
+	}
+	rm.tagsMu.RUnlock()
+
+	rm.messages.SaveMeta(meta)
+}
+
+//modeString returns the single-letter IRC-style name of a single RoomMode flag.
+func modeString(m RoomMode) string {
+	switch m {
+	case ModeInviteOnly:
+		return "i"
+	case ModeModerated:
+		return "m"
+	case ModeSecret:
+		return "s"
+	default:
+		return "?"
+	}
+}
+
+//Join adds cl to the room, enforcing the room's key, invite list, and client
+//limit. It returns ErrBadKey, ErrInviteOnly, or ErrRoomFull if cl does not
+//meet the room's requirements.
+func (rm *Room) Join(cl Client, key string) error {
+	rm.meta.RLock()
+	if rm.modes&ModeInviteOnly != 0 {
+		if _, invited := rm.invited[cl.Name()]; !invited {
+			rm.meta.RUnlock()
+			return ErrInviteOnly
+		}
+	}
+	if rm.key != "" && key != rm.key {
+		rm.meta.RUnlock()
+		return ErrBadKey
+	}
+	limit := rm.limit
+	rm.meta.RUnlock()
+	if !rm.clients.AddIfUnderLimit(cl, limit, func(c Client) Client { return rm.wrap(c) }) {
+		return ErrRoomFull
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package room
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestJoinEnforcesKey(t *testing.T) {
+	rm := NewRoom("secret")
+	rm.SetKey("hunter2")
+
+	if err := rm.Join(&testClient{name: "alice"}, "wrong"); err != ErrBadKey {
+		t.Fatalf("expected ErrBadKey, got %v", err)
+	}
+	if err := rm.Join(&testClient{name: "alice"}, "hunter2"); err != nil {
+		t.Fatalf("expected Join to succeed with the right key, got %v", err)
+	}
+	if !rm.Present("alice") {
+		t.Fatal("expected alice to be in the room after Join")
+	}
+}
+
+func TestJoinEnforcesInviteOnly(t *testing.T) {
+	rm := NewRoom("vip")
+	rm.SetMode(ModeInviteOnly)
+
+	if err := rm.Join(&testClient{name: "bob"}, ""); err != ErrInviteOnly {
+		t.Fatalf("expected ErrInviteOnly, got %v", err)
+	}
+	rm.Invite("bob")
+	if err := rm.Join(&testClient{name: "bob"}, ""); err != nil {
+		t.Fatalf("expected Join to succeed once invited, got %v", err)
+	}
+}
+
+func TestJoinEnforcesLimit(t *testing.T) {
+	rm := NewRoom("small")
+	rm.SetLimit(1)
+
+	if err := rm.Join(&testClient{name: "alice"}, ""); err != nil {
+		t.Fatalf("expected first Join to succeed, got %v", err)
+	}
+	if err := rm.Join(&testClient{name: "bob"}, ""); err != ErrRoomFull {
+		t.Fatalf("expected ErrRoomFull, got %v", err)
+	}
+}
+
+//TestJoinLimitIsAtomicUnderConcurrency guards against the limit check and the
+//add happening under separate locks, which let concurrent Joins both observe
+//room for one more client and both be admitted, leaving the room over limit.
+//Run with -race to also catch unguarded access.
+func TestJoinLimitIsAtomicUnderConcurrency(t *testing.T) {
+	rm := NewRoom("crowded")
+	rm.SetLimit(10)
+
+	var admitted int64
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		cl := &testClient{name: fmt.Sprintf("client%d", i)}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := rm.Join(cl, ""); err == nil {
+				atomic.AddInt64(&admitted, 1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if admitted != 10 {
+		t.Fatalf("expected exactly 10 clients admitted under a limit of 10, got %d", admitted)
+	}
+	if n := rm.NumberOfClients(); n != 10 {
+		t.Fatalf("expected room to hold exactly 10 clients, got %d", n)
+	}
+}
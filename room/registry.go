@@ -0,0 +1,158 @@
+package room
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+//Registry owns the set of currently loaded rooms, keyed by name. It is the
+//single integration point for any chat transport built on this package.
+type Registry struct {
+	mu         sync.RWMutex
+	storageDir string
+	maxLoaded  int
+	rooms      map[string]*Room
+	touched    map[string]time.Time
+
+	//OnCreate, if set, is called after a room is created or rehydrated.
+	OnCreate func(rm *Room)
+	//OnDelete, if set, is called after a room is removed from the registry.
+	OnDelete func(name string)
+	//OnEmpty, if set, is called after a room's last client leaves.
+	OnEmpty func(rm *Room)
+}
+
+//NewRegistry returns a Registry backed by storageDir, holding at most
+//maxLoaded rooms in memory at once. A maxLoaded of 0 means unlimited.
+func NewRegistry(storageDir string, maxLoaded int) *Registry {
+	return &Registry{
+		storageDir: storageDir,
+		maxLoaded:  maxLoaded,
+		rooms:      make(map[string]*Room),
+		touched:    make(map[string]time.Time),
+	}
+}
+
+//GetOrCreate returns the room named name, loading it from disk or creating
+//it if it is not already in memory.
+func (r *Registry) GetOrCreate(name string) (*Room, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if rm, found := r.rooms[name]; found {
+		r.touched[name] = time.Now()
+		return rm, nil
+	}
+	r.evictLocked(r.maxLoaded - 1)
+	rm, err := NewRoomWithHistory(name, r.storageDir)
+	if err != nil {
+		return nil, err
+	}
+	if err := rm.LoadHistory(); err != nil {
+		rm.messages.Close()
+		return nil, err
+	}
+	rm.registry = r
+	r.rooms[name] = rm
+	r.touched[name] = time.Now()
+	if r.OnCreate != nil {
+		r.OnCreate(rm)
+	}
+	return rm, nil
+}
+
+//Get returns the room named name if it is currently loaded.
+func (r *Registry) Get(name string) (*Room, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	rm, found := r.rooms[name]
+	return rm, found
+}
+
+//List returns the names of all currently loaded rooms that aren't secret, sorted.
+func (r *Registry) List() []string {
+	r.mu.RLock()
+	rooms := make([]*Room, 0, len(r.rooms))
+	for _, rm := range r.rooms {
+		rooms = append(rooms, rm)
+	}
+	r.mu.RUnlock()
+
+	names := make([]string, 0, len(rooms))
+	for _, rm := range rooms {
+		if !rm.HasMode(ModeSecret) {
+			names = append(names, rm.Name())
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+//Delete removes the room named name from the registry and closes its
+//history store. Its persisted messages and mode state are left on disk, so a
+//later GetOrCreate rehydrates it.
+func (r *Registry) Delete(name string) {
+	r.mu.Lock()
+	rm, found := r.rooms[name]
+	if found {
+		delete(r.rooms, name)
+		delete(r.touched, name)
+	}
+	r.mu.Unlock()
+	if !found {
+		return
+	}
+	rm.messages.Close()
+	if r.OnDelete != nil {
+		r.OnDelete(name)
+	}
+}
+
+//Touch updates name's recency so it is less likely to be evicted. Room.Send
+//and Room.Recieve call this on every message.
+func (r *Registry) Touch(name string) {
+	r.mu.Lock()
+	if _, found := r.rooms[name]; found {
+		r.touched[name] = time.Now()
+	}
+	r.mu.Unlock()
+}
+
+//NotifyEmpty runs the registry's OnEmpty hook for rm. Callers that remove the
+//last client from a room should call this afterwards.
+func (r *Registry) NotifyEmpty(rm *Room) {
+	if r.OnEmpty != nil {
+		r.OnEmpty(rm)
+	}
+}
+
+//evictLocked evicts the least-recently-touched empty rooms until the
+//registry holds at most target rooms. It must be called with r.mu held,
+//before the room being created is inserted into r.rooms, so that room is
+//never itself a candidate. It is best-effort: if every loaded room has
+//clients in it, the cap is exceeded rather than disconnecting anyone.
+func (r *Registry) evictLocked(target int) {
+	if r.maxLoaded <= 0 || len(r.rooms) <= target {
+		return
+	}
+	type candidate struct {
+		name    string
+		touched time.Time
+	}
+	candidates := make([]candidate, 0, len(r.rooms))
+	for name, rm := range r.rooms {
+		if rm.IsEmpty() {
+			candidates = append(candidates, candidate{name, r.touched[name]})
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].touched.Before(candidates[j].touched) })
+	for _, c := range candidates {
+		if len(r.rooms) <= target {
+			return
+		}
+		rm := r.rooms[c.name]
+		rm.messages.Close()
+		delete(r.rooms, c.name)
+		delete(r.touched, c.name)
+	}
+}
@@ -0,0 +1,109 @@
+package room
+
+import "testing"
+
+func TestRegistryGetOrCreateReturnsSameRoom(t *testing.T) {
+	reg := NewRegistry(t.TempDir(), 0)
+	first, err := reg.GetOrCreate("general")
+	if err != nil {
+		t.Fatalf("GetOrCreate: %v", err)
+	}
+	second, err := reg.GetOrCreate("general")
+	if err != nil {
+		t.Fatalf("GetOrCreate: %v", err)
+	}
+	if first != second {
+		t.Fatal("expected GetOrCreate to return the same room on repeat calls")
+	}
+}
+
+func TestRegistryLifecycleHooks(t *testing.T) {
+	reg := NewRegistry(t.TempDir(), 0)
+	var created, deleted, emptied string
+	reg.OnCreate = func(rm *Room) { created = rm.Name() }
+	reg.OnDelete = func(name string) { deleted = name }
+	reg.OnEmpty = func(rm *Room) { emptied = rm.Name() }
+
+	rm, err := reg.GetOrCreate("general")
+	if err != nil {
+		t.Fatalf("GetOrCreate: %v", err)
+	}
+	if created != "general" {
+		t.Fatalf("expected OnCreate to fire for general, got %q", created)
+	}
+
+	rm.Add(&testClient{name: "alice"})
+	rm.Remove(&testClient{name: "alice"})
+	if emptied != "general" {
+		t.Fatalf("expected OnEmpty to fire once the last client left, got %q", emptied)
+	}
+
+	reg.Delete("general")
+	if deleted != "general" {
+		t.Fatalf("expected OnDelete to fire for general, got %q", deleted)
+	}
+	if _, found := reg.Get("general"); found {
+		t.Fatal("expected general to no longer be loaded after Delete")
+	}
+}
+
+func TestRegistryEvictsLeastRecentlyTouchedEmptyRoom(t *testing.T) {
+	reg := NewRegistry(t.TempDir(), 2)
+
+	a, err := reg.GetOrCreate("a")
+	if err != nil {
+		t.Fatalf("GetOrCreate a: %v", err)
+	}
+	a.Add(&testClient{name: "alice"}) // non-empty, should survive eviction
+
+	if _, err := reg.GetOrCreate("b"); err != nil {
+		t.Fatalf("GetOrCreate b: %v", err)
+	}
+	if _, err := reg.GetOrCreate("c"); err != nil {
+		t.Fatalf("GetOrCreate c: %v", err)
+	}
+
+	if _, found := reg.Get("a"); !found {
+		t.Fatal("expected non-empty room a to survive eviction")
+	}
+	if _, found := reg.Get("b"); found {
+		t.Fatal("expected empty, least-recently-touched room b to be evicted")
+	}
+	if len(reg.List()) > 2 {
+		t.Fatalf("expected at most 2 loaded rooms, got %d", len(reg.List()))
+	}
+}
+
+//TestRegistryGetOrCreateDoesNotEvictTheRoomItJustCreated guards against
+//evictLocked running after the new room is already inserted, which would
+//make it the only empty candidate and evict the very room being returned.
+func TestRegistryGetOrCreateDoesNotEvictTheRoomItJustCreated(t *testing.T) {
+	reg := NewRegistry(t.TempDir(), 1)
+
+	a, err := reg.GetOrCreate("a")
+	if err != nil {
+		t.Fatalf("GetOrCreate a: %v", err)
+	}
+	a.Add(&testClient{name: "alice"}) // non-empty, so "b" is the only evictable room
+
+	b, err := reg.GetOrCreate("b")
+	if err != nil {
+		t.Fatalf("GetOrCreate b: %v", err)
+	}
+
+	got, found := reg.Get("b")
+	if !found {
+		t.Fatal("expected b to still be reachable via Get immediately after GetOrCreate")
+	}
+	if got != b {
+		t.Fatal("expected Get to return the same *Room instance GetOrCreate just returned")
+	}
+
+	again, err := reg.GetOrCreate("b")
+	if err != nil {
+		t.Fatalf("GetOrCreate b again: %v", err)
+	}
+	if again != b {
+		t.Fatal("expected a second GetOrCreate to return the same *Room, not a split-brain duplicate")
+	}
+}
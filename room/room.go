@@ -1,8 +1,7 @@
 package room
 
 import (
-	"container/list"
-	"fmt"
+	"errors"
 	"github.com/davidafox/chat/message"
 	"sort"
 	"sync"
@@ -13,98 +12,226 @@ type Client interface {
 	Equals(other Client) bool
 	Name() string
 	Recieve(m message.Message)
+	//Close releases any resources held for the client. Rooms call it when
+	//reaping a client that has disconnected or repeatedly overflowed.
+	Close()
 }
 
-//clientList is a mutex enhanced linked list of clients.
+//clientList is a concurrency safe set of clients keyed by name.
 type clientList struct {
-	*list.List
-	*sync.Mutex
-	count int
+	sync.RWMutex
+	clients map[string]Client
 }
 
 //NewClientList returns a pointer to an empty clientList.
 func NewClientList() *clientList {
-	return &clientList{list.New(), new(sync.Mutex), 0}
+	return &clientList{clients: make(map[string]Client)}
 }
 
-//Add adds the object c to the back of the list.
+//Add adds the client c to the list, closing and replacing any existing
+//client with the same name.
 func (c *clientList) Add(cl Client) {
 	c.Lock()
-	c.count++
-	c.PushBack(cl)
+	existing := c.clients[cl.Name()]
+	c.clients[cl.Name()] = cl
 	c.Unlock()
+	if existing != nil {
+		existing.Close()
+	}
 }
 
-//Rem removes all clients from the list that are equal to c.
-func (c *clientList) Rem(cl Client) bool {
+//AddIfUnderLimit wraps cl with wrap and adds it to the list, unless the list
+//already holds limit or more clients under some other name, in which case it
+//leaves the list unchanged and returns false. A limit of 0 means unlimited.
+//Reconnecting under a name already in the list never counts as growing it.
+//The check and the add happen under the same lock, so concurrent callers
+//can't both observe room for one more client and both be admitted.
+func (c *clientList) AddIfUnderLimit(cl Client, limit int, wrap func(Client) Client) bool {
 	c.Lock()
-	found := false
-	for i, x := c.Front(), c.Front(); i != nil; {
-		if other, ok := i.Value.(Client); ok {
-			if cl.Equals(other) {
-				x = i
-				i = i.Next()
-				c.Remove(x)
-				c.count--
-				found = true
-			} else {
-				i = i.Next()
-			}
-		} else {
-			i = i.Next()
-		}
+	existing, found := c.clients[cl.Name()]
+	if limit > 0 && !found && len(c.clients) >= limit {
+		c.Unlock()
+		return false
 	}
+	c.clients[cl.Name()] = wrap(cl)
 	c.Unlock()
-	return found
+	if existing != nil {
+		existing.Close()
+	}
+	return true
+}
+
+//Rem removes the client equal to cl from the list, returning the removed
+//client and true if one was found.
+func (c *clientList) Rem(cl Client) (Client, bool) {
+	c.Lock()
+	defer c.Unlock()
+	existing, found := c.clients[cl.Name()]
+	if !found || !existing.Equals(cl) {
+		return nil, false
+	}
+	delete(c.clients, cl.Name())
+	return existing, true
 }
 
 //Who returns a []string with all the names of the clients in the list sorted.
 func (c *clientList) Who() []string {
-	clist := make([]string, 0, 0)
-	for i := c.Front(); i != nil; i = i.Next() {
-		clist = append(clist, i.Value.(Client).Name())
+	c.RLock()
+	clist := make([]string, 0, len(c.clients))
+	for name := range c.clients {
+		clist = append(clist, name)
 	}
+	c.RUnlock()
 	sort.Strings(clist)
 	return clist
 }
 
 //Present returns true if a client with matching name is in the clientlist.
 func (c *clientList) Present(name string) bool {
-	found := false
-	for i := c.Front(); i != nil; i = i.Next() {
-		if i.Value.(Client).Name() == name {
-			found = true
-		}
-	}
+	c.RLock()
+	_, found := c.clients[name]
+	c.RUnlock()
 	return found
 }
 
-//GetClient returns the first client with matching name.
+//GetClient returns the client with matching name.
 func (c *clientList) GetClient(name string) Client {
-	for i := c.Front(); i != nil; i = i.Next() {
-		if i.Value.(Client).Name() == name {
-			return i.Value.(Client)
-		}
+	c.RLock()
+	cl := c.clients[name]
+	c.RUnlock()
+	return cl
+}
+
+//snapshot returns a copy of the current clients, safe to range over without holding the lock.
+func (c *clientList) snapshot() []Client {
+	c.RLock()
+	clist := make([]Client, 0, len(c.clients))
+	for _, cl := range c.clients {
+		clist = append(clist, cl)
 	}
-	return nil
+	c.RUnlock()
+	return clist
 }
 
+//count returns the number of clients in the list.
+func (c *clientList) count() int {
+	c.RLock()
+	n := len(c.clients)
+	c.RUnlock()
+	return n
+}
+
+//recentCacheSize is how many messages LoadHistory pulls into memory on startup.
+const recentCacheSize = 200
+
 //Room is a room name and a linked list of clients in the room.
 type Room struct {
 	name     string
 	clients  *clientList
-	messages *message.MessageList
+	messages history
+	recent   *message.MessageList
+
+	meta    sync.RWMutex
+	topic   string
+	key     string
+	modes   RoomMode
+	limit   int
+	invited map[string]struct{}
+
+	tagsMu sync.RWMutex
+	tags   map[string]map[string]float64
+	direct map[string]bool
+
+	registry *Registry
+
+	deliveryMu     sync.RWMutex
+	bufferSize     int
+	overflowPolicy OverflowPolicy
 }
 
-//NewRoom creates a room with name.
+//defaultBufferSize is how many messages a client's buffer holds before its
+//OverflowPolicy kicks in.
+const defaultBufferSize = 32
+
+//NewRoom creates a room with name. Its message log is kept in memory only;
+//use NewRoomWithHistory for a room whose history survives a restart.
 func NewRoom(name string) *Room {
 	newRoom := new(Room)
 	newRoom.name = name
 	newRoom.clients = NewClientList()
-	newRoom.messages = message.NewMessageList()
+	newRoom.messages = newMemHistory()
+	newRoom.recent = message.NewMessageList()
+	newRoom.invited = make(map[string]struct{})
+	newRoom.tags = make(map[string]map[string]float64)
+	newRoom.direct = make(map[string]bool)
+	newRoom.bufferSize = defaultBufferSize
+	newRoom.overflowPolicy = DropOldest
 	return newRoom
 }
 
+//NewRoomWithHistory creates a room with name whose messages are persisted to
+//storageDir, so they survive a server restart. Call LoadHistory afterwards to
+//populate the room's recent messages on startup.
+func NewRoomWithHistory(name, storageDir string) (*Room, error) {
+	h, err := openBoltHistory(storageDir, name)
+	if err != nil {
+		return nil, err
+	}
+	newRoom := new(Room)
+	newRoom.name = name
+	newRoom.clients = NewClientList()
+	newRoom.messages = h
+	newRoom.recent = message.NewMessageList()
+	newRoom.invited = make(map[string]struct{})
+	newRoom.tags = make(map[string]map[string]float64)
+	newRoom.direct = make(map[string]bool)
+	newRoom.bufferSize = defaultBufferSize
+	newRoom.overflowPolicy = DropOldest
+	return newRoom, nil
+}
+
+//LoadHistory pulls the most recent messages from the room's persistent
+//history store into memory, so they're available immediately on startup
+//without a disk read. It is a no-op for rooms created with NewRoom.
+func (rm *Room) LoadHistory() error {
+	if _, inMemory := rm.messages.(*memHistory); inMemory {
+		return nil
+	}
+	msgs, _, err := rm.messages.Recent(0, recentCacheSize)
+	if err != nil {
+		return err
+	}
+	rm.recent.Lock()
+	for _, m := range msgs {
+		rm.recent.PushBack(m)
+	}
+	rm.recent.Unlock()
+
+	meta, err := rm.messages.LoadMeta()
+	if err != nil {
+		return err
+	}
+	rm.meta.Lock()
+	rm.topic = meta.Topic
+	rm.key = meta.Key
+	rm.modes = meta.Modes
+	rm.limit = meta.Limit
+	for _, name := range meta.Invited {
+		rm.invited[name] = struct{}{}
+	}
+	rm.meta.Unlock()
+
+	rm.tagsMu.Lock()
+	for clientName, tags := range meta.Tags {
+		rm.tags[clientName] = tags
+	}
+	for _, name := range meta.Direct {
+		rm.direct[name] = true
+	}
+	rm.tagsMu.Unlock()
+	return nil
+}
+
 //Equals returns true if the rooms have the same name.
 func (rm *Room) Equals(other Client) bool {
 	if c, ok := other.(*Room); ok {
@@ -118,6 +245,12 @@ func (rm *Room) Name() string {
 	return rm.name
 }
 
+//Close releases the room's resources, closing its history store. It
+//satisfies the Client interface so a Room can be nested as another room's client.
+func (rm *Room) Close() {
+	rm.messages.Close()
+}
+
 //Who returns a slice of the names of all the clients in the rooms client list.
 func (rm *Room) Who() []string {
 	return rm.clients.Who()
@@ -127,48 +260,90 @@ func (rm *Room) Present(name string) bool {
 	return rm.clients.Present(name)
 }
 
-//Remove removes a client from the room.
+//Remove removes a client from the room, closing it so its delivery goroutine
+//doesn't leak.
 func (rm *Room) Remove(cl Client) bool {
-	return rm.clients.Rem(cl)
+	removed, found := rm.clients.Rem(cl)
+	if !found {
+		return false
+	}
+	removed.Close()
+	if rm.IsEmpty() && rm.registry != nil {
+		rm.registry.NotifyEmpty(rm)
+	}
+	return true
 }
 
-//Add adds a client to a room.
+//Add adds a client to a room, bypassing its key, invite, and limit checks.
+//Deprecated: use Join instead.
 func (rm *Room) Add(cl Client) {
-	rm.clients.Add(cl)
+	rm.clients.Add(rm.wrap(cl))
+}
+
+//SetDeliveryPolicy sets the buffer size and overflow policy used for clients
+//added to the room from now on. It does not affect clients already in the room.
+func (rm *Room) SetDeliveryPolicy(bufferSize int, policy OverflowPolicy) {
+	rm.deliveryMu.Lock()
+	rm.bufferSize = bufferSize
+	rm.overflowPolicy = policy
+	rm.deliveryMu.Unlock()
+}
+
+//wrap wraps cl in a BufferedClient so a slow client can't stall Send/Recieve
+//for the rest of the room, reaping cl if its policy is DisconnectOnOverflow.
+func (rm *Room) wrap(cl Client) *BufferedClient {
+	rm.deliveryMu.RLock()
+	bufferSize, policy := rm.bufferSize, rm.overflowPolicy
+	rm.deliveryMu.RUnlock()
+	bc := NewBufferedClient(cl, bufferSize, policy)
+	bc.onDisconnect = func() {
+		//Leave a client that has already replaced bc under the same name alone.
+		if rm.clients.GetClient(bc.Name()) == Client(bc) {
+			rm.Remove(bc)
+		}
+	}
+	return bc
 }
 
 //Tell sends a string to the room from the server.
-func (rm Room) Tell(s string) {
+func (rm *Room) Tell(s string) {
 	msg := message.NewServerMessage(s)
 	rm.Send(msg)
 }
 
 //Send puts the message into each client in the room's recieve function.
 func (rm *Room) Send(m message.Message) {
-	for i := rm.clients.Front(); i != nil; i = i.Next() {
-		i.Value.(Client).Recieve(m)
+	for _, cl := range rm.clients.snapshot() {
+		cl.Recieve(m)
 	}
-	rm.messages.Lock()
-	rm.messages.PushBack(m)
-	rm.messages.Unlock()
+	rm.record(m)
 }
 
 //Recieve passes messages the room recieves to all clients in the room's client list.
 func (rm *Room) Recieve(m message.Message) {
-	for i := rm.clients.Front(); i != nil; i = i.Next() {
-		i.Value.(Client).Recieve(m)
+	for _, cl := range rm.clients.snapshot() {
+		cl.Recieve(m)
+	}
+	rm.record(m)
+}
+
+//record appends m to the room's history store and recent-message cache.
+func (rm *Room) record(m message.Message) {
+	rm.messages.Append(m)
+	rm.recent.Lock()
+	rm.recent.PushBack(m)
+	if rm.recent.Len() > recentCacheSize {
+		rm.recent.Remove(rm.recent.Front())
+	}
+	rm.recent.Unlock()
+	if rm.registry != nil {
+		rm.registry.Touch(rm.name)
 	}
-	rm.messages.Lock()
-	rm.messages.PushBack(m)
-	rm.messages.Unlock()
 }
 
 //IsEmpty returns true if the room is empty.
 func (rm *Room) IsEmpty() bool {
-	if rm.clients.Front() == nil {
-		return true
-	}
-	return false
+	return rm.clients.count() == 0
 }
 
 //GetClient returns the first client with name from the room.
@@ -176,15 +351,20 @@ func (rm *Room) GetClient(name string) Client {
 	return rm.clients.GetClient(name)
 }
 
-//GetMessages gets the messages from the room message list and returns them as a []string.
-func (rm Room) GetMessages() []string {
-	m := make([]string, rm.messages.Len(), rm.messages.Len())
-	for i, x := rm.messages.Front(), 0; i != nil; i, x = i.Next(), x+1 {
-		m[x] = fmt.Sprint(i.Value)
+//ErrInvalidLimit is returned by GetMessages when limit is not positive.
+var ErrInvalidLimit = errors.New("room: limit must be positive")
+
+//GetMessages returns up to limit messages older than the before cursor (or
+//the newest limit messages if before is 0), along with a cursor to pass as
+//before to fetch the next older page. A returned cursor of 0 means there is
+//nothing older left to fetch. It returns ErrInvalidLimit if limit is not positive.
+func (rm *Room) GetMessages(before uint64, limit int) ([]message.Message, uint64, error) {
+	if limit <= 0 {
+		return nil, 0, ErrInvalidLimit
 	}
-	return m
+	return rm.messages.Recent(before, limit)
 }
 
-func (rm Room) NumberOfClients() int {
-	return rm.clients.count
+func (rm *Room) NumberOfClients() int {
+	return rm.clients.count()
 }
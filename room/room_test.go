@@ -0,0 +1,159 @@
+package room
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/davidafox/chat/message"
+)
+
+//testClient is a minimal Client used to exercise clientList/Room concurrency.
+type testClient struct {
+	name string
+}
+
+func (c *testClient) Equals(other Client) bool {
+	if o, ok := other.(*testClient); ok {
+		return c.name == o.name
+	}
+	return false
+}
+
+func (c *testClient) Name() string {
+	return c.name
+}
+
+func (c *testClient) Recieve(m message.Message) {}
+
+func (c *testClient) Close() {}
+
+//TestClientListConcurrentAddRemRace adds and removes clients from many
+//goroutines at once; run with -race to catch unguarded access.
+func TestClientListConcurrentAddRemRace(t *testing.T) {
+	cl := NewClientList()
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		c := &testClient{name: fmt.Sprintf("client%d", i)}
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			cl.Add(c)
+		}()
+		go func() {
+			defer wg.Done()
+			cl.Who()
+			cl.Present(c.name)
+			cl.GetClient(c.name)
+		}()
+		go func() {
+			defer wg.Done()
+			cl.Rem(c)
+		}()
+	}
+	wg.Wait()
+}
+
+//TestRoomConcurrentSendRace sends and receives messages from many goroutines
+//while clients join and leave; run with -race to catch unguarded access.
+func TestRoomConcurrentSendRace(t *testing.T) {
+	rm := NewRoom("race")
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		c := &testClient{name: fmt.Sprintf("client%d", i)}
+		rm.Add(c)
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			rm.Send(message.NewServerMessage("hi"))
+		}()
+		go func() {
+			defer wg.Done()
+			rm.Remove(c)
+		}()
+	}
+	wg.Wait()
+}
+
+func BenchmarkClientListWho(b *testing.B) {
+	cl := NewClientList()
+	for i := 0; i < 1000; i++ {
+		cl.Add(&testClient{name: fmt.Sprintf("client%d", i)})
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		cl.Who()
+	}
+}
+
+func BenchmarkClientListGetClient(b *testing.B) {
+	cl := NewClientList()
+	for i := 0; i < 1000; i++ {
+		cl.Add(&testClient{name: fmt.Sprintf("client%d", i)})
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		cl.GetClient("client500")
+	}
+}
+
+//blockingTestClient never returns from Recieve until release is closed, so
+//tests can force a BufferedClient's queue to fill up.
+type blockingTestClient struct {
+	testClient
+	release chan struct{}
+	got     chan message.Message
+}
+
+func newBlockingTestClient(name string) *blockingTestClient {
+	return &blockingTestClient{testClient: testClient{name: name}, release: make(chan struct{}), got: make(chan message.Message, 16)}
+}
+
+func (c *blockingTestClient) Recieve(m message.Message) {
+	c.got <- m
+	<-c.release
+}
+
+func TestBufferedClientDropNewestDiscardsIncoming(t *testing.T) {
+	cl := newBlockingTestClient("slow")
+	bc := NewBufferedClient(cl, 1, DropNewest)
+	defer func() { close(cl.release); bc.Close() }()
+
+	bc.Recieve(message.NewServerMessage("1")) // picked up by drain, blocks it on release
+	<-cl.got
+	bc.Recieve(message.NewServerMessage("2")) // fills the 1-slot queue
+	bc.Recieve(message.NewServerMessage("3")) // queue full, should be dropped
+
+	if d := bc.Dropped(); d != 1 {
+		t.Fatalf("expected 1 dropped message, got %d", d)
+	}
+}
+
+func TestBufferedClientDisconnectOnOverflowReapsClient(t *testing.T) {
+	cl := newBlockingTestClient("slow")
+	var reaped bool
+	bc := NewBufferedClient(cl, 1, DisconnectOnOverflow)
+	bc.onDisconnect = func() { reaped = true }
+	defer close(cl.release)
+
+	bc.Recieve(message.NewServerMessage("1"))
+	<-cl.got
+	bc.Recieve(message.NewServerMessage("2")) // fills the 1-slot queue
+	bc.Recieve(message.NewServerMessage("3")) // overflow triggers disconnect
+
+	if !reaped {
+		t.Fatal("expected onDisconnect to be called on overflow")
+	}
+}
+
+func BenchmarkRoomSend(b *testing.B) {
+	rm := NewRoom("bench")
+	for i := 0; i < 100; i++ {
+		rm.Add(&testClient{name: fmt.Sprintf("client%d", i)})
+	}
+	m := message.NewServerMessage("hi")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		rm.Send(m)
+	}
+}
@@ -0,0 +1,62 @@
+package room
+
+import "sort"
+
+//RoomTag is a client-assigned tag on a room, such as Matrix's m.favourite or
+//m.lowpriority, together with the sort order of the room within that tag.
+type RoomTag struct {
+	Name  string
+	Order float64
+}
+
+//AddTag tags the room with tag for clientName, at the given sort order. If
+//clientName already has tag, its order is updated.
+func (rm *Room) AddTag(clientName, tag string, order float64) {
+	rm.tagsMu.Lock()
+	if rm.tags[clientName] == nil {
+		rm.tags[clientName] = make(map[string]float64)
+	}
+	rm.tags[clientName][tag] = order
+	rm.tagsMu.Unlock()
+	rm.persistMeta()
+}
+
+//RemoveTag removes tag from clientName's tags on this room.
+func (rm *Room) RemoveTag(clientName, tag string) {
+	rm.tagsMu.Lock()
+	delete(rm.tags[clientName], tag)
+	rm.tagsMu.Unlock()
+	rm.persistMeta()
+}
+
+//Tags returns clientName's tags on this room, sorted by tag name.
+func (rm *Room) Tags(clientName string) []RoomTag {
+	rm.tagsMu.RLock()
+	defer rm.tagsMu.RUnlock()
+	tags := rm.tags[clientName]
+	result := make([]RoomTag, 0, len(tags))
+	for name, order := range tags {
+		result = append(result, RoomTag{Name: name, Order: order})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
+
+//MarkDirect records whether clientName considers this room a direct chat.
+func (rm *Room) MarkDirect(clientName string, isDirect bool) {
+	rm.tagsMu.Lock()
+	if isDirect {
+		rm.direct[clientName] = true
+	} else {
+		delete(rm.direct, clientName)
+	}
+	rm.tagsMu.Unlock()
+	rm.persistMeta()
+}
+
+//IsDirect returns true if clientName has marked this room as a direct chat.
+func (rm *Room) IsDirect(clientName string) bool {
+	rm.tagsMu.RLock()
+	defer rm.tagsMu.RUnlock()
+	return rm.direct[clientName]
+}
@@ -0,0 +1,43 @@
+package room
+
+import "testing"
+
+func TestAddTagAndRemoveTag(t *testing.T) {
+	rm := NewRoom("general")
+	rm.AddTag("alice", "m.favourite", 1.5)
+	rm.AddTag("alice", "m.lowpriority", -1)
+	rm.AddTag("bob", "m.favourite", 0)
+
+	tags := rm.Tags("alice")
+	if len(tags) != 2 {
+		t.Fatalf("expected 2 tags for alice, got %d", len(tags))
+	}
+
+	rm.RemoveTag("alice", "m.lowpriority")
+	tags = rm.Tags("alice")
+	if len(tags) != 1 || tags[0].Name != "m.favourite" || tags[0].Order != 1.5 {
+		t.Fatalf("unexpected tags after RemoveTag: %v", tags)
+	}
+
+	if len(rm.Tags("bob")) != 1 {
+		t.Fatal("expected bob's tags to be unaffected by alice's changes")
+	}
+}
+
+func TestMarkDirect(t *testing.T) {
+	rm := NewRoom("dm-alice-bob")
+	if rm.IsDirect("alice") {
+		t.Fatal("expected a new room to not be marked direct")
+	}
+	rm.MarkDirect("alice", true)
+	if !rm.IsDirect("alice") {
+		t.Fatal("expected alice to see the room as direct after MarkDirect(true)")
+	}
+	if rm.IsDirect("bob") {
+		t.Fatal("expected MarkDirect to be per-client")
+	}
+	rm.MarkDirect("alice", false)
+	if rm.IsDirect("alice") {
+		t.Fatal("expected alice to no longer see the room as direct")
+	}
+}